@@ -1,6 +1,7 @@
 package contract
 
 import (
+	"context"
 	"math/rand"
 )
 
@@ -11,13 +12,19 @@ var ContractConfig = &PluginConfig{
 	Name:                  "send",
 	Id:                    1,
 	Version:               1,
-	SupportedTransactions: []string{"send"},
+	SupportedTransactions: []string{"send", "send_on_behalf", "withdraw_from_reserve", "multi_send", "grant_fee_allowance"},
 }
 
 // Contract() defines the smart contract that implements the extended logic of the nested chain
 type Contract struct {
-	FSMConfig *PluginFSMConfig // fsm configuration
-	plugin    Plugin           // plugin connection
+	FSMConfig    *PluginFSMConfig // fsm configuration
+	plugin       Plugin           // plugin connection
+	gasParams    *GasParams       // dynamic base fee parameters loaded for the block currently being applied
+	blockGasUsed uint64           // running total of gas used by the block currently being applied
+	proposer     []byte           // block proposer address, credited its share of this block's fees
+	blockFees    uint64           // running total of fees collected by the block currently being applied
+	feeParams    *FeeParams       // last FeeParams read, reused across Mempool-mode CheckTx calls to skip a re-read
+	height       uint64           // height of the block currently being applied, used to compute fee-allowance epochs
 }
 
 // Genesis() implements logic to import a json file to create the state at height 0 and export the state at any height
@@ -26,33 +33,56 @@ func (c *Contract) Genesis(_ *PluginGenesisRequest) *PluginGenesisResponse {
 }
 
 // BeginBlock() is code that is executed at the start of `applying` the block
-func (c *Contract) BeginBlock(_ *PluginBeginRequest) *PluginBeginResponse {
-	return &PluginBeginResponse{}
-}
-
-// CheckTx() is code that is executed to statelessly validate a transaction
-func (c *Contract) CheckTx(request *PluginCheckRequest) *PluginCheckResponse {
-	// validate fee
-	resp, err := c.plugin.StateRead(c, &PluginStateReadRequest{
+func (c *Contract) BeginBlock(request *PluginBeginRequest) *PluginBeginResponse {
+	ctx, cancel := execContext(request.ExecMode, request.Deadline)
+	defer cancel()
+	// load the dynamic base fee parameters (and utilization window) as of the end of the previous block
+	resp, err := c.plugin.StateRead(ctx, c, &PluginStateReadRequest{
 		Keys: []*PluginKeyRead{
-			{QueryId: rand.Uint64(), Key: KeyForFeeParams()},
+			{QueryId: rand.Uint64(), Key: KeyForGasParams()},
 		}})
 	if err == nil {
 		err = resp.Error
 	}
-	// handle error
+	if err == nil && ctx.Err() != nil {
+		err = ErrExecTimeout()
+	}
 	if err != nil {
-		return &PluginCheckResponse{Error: err}
+		return &PluginBeginResponse{Error: err}
 	}
-	// convert bytes into fee parameters
-	minFees := new(FeeParams)
-	if err = Unmarshal(resp.Results[0].Entries[0].Value, minFees); err != nil {
+	gasParams := new(GasParams)
+	if err = Unmarshal(resp.Results[0].Entries[0].Value, gasParams); err != nil {
+		return &PluginBeginResponse{Error: err}
+	}
+	// reset the per-block gas/fee accounting, cache the params for CheckTx / EndBlock, and record the proposer to credit
+	c.gasParams, c.blockGasUsed, c.blockFees, c.proposer, c.height = gasParams, 0, 0, request.ProposerAddress, request.Height
+	// drop the cached FeeParams so a Mempool-mode CheckTx never reuses a copy loaded before this block
+	c.feeParams = nil
+	return &PluginBeginResponse{}
+}
+
+// CheckTx() is code that is executed to statelessly validate a transaction
+func (c *Contract) CheckTx(request *PluginCheckRequest) *PluginCheckResponse {
+	ctx, cancel := execContext(request.ExecMode, request.Deadline)
+	defer cancel()
+	// in Mempool mode, FeeParams cached since the current block began are reused instead of re-read
+	minFees, err := c.loadFeeParams(ctx, request.ExecMode)
+	if err != nil {
 		return &PluginCheckResponse{Error: err}
 	}
 	// check for the minimum fee
 	if request.Tx.Fee < minFees.SendFee {
 		return &PluginCheckResponse{Error: ErrTxFeeBelowStateLimit()}
 	}
+	// check the dynamic base fee: the tx must also cover BaseFeePerGas * GasWanted, checked separately from (not
+	// added to) the flat floor above; guard
+	// the multiplication against uint64 overflow so a crafted GasWanted can't wrap the product down to a small value
+	if c.gasParams != nil && c.gasParams.BaseFeePerGas != 0 {
+		required := c.gasParams.BaseFeePerGas * request.Tx.GasWanted
+		if required/c.gasParams.BaseFeePerGas != request.Tx.GasWanted || request.Tx.Fee < required {
+			return &PluginCheckResponse{Error: ErrTxFeeBelowStateLimit()}
+		}
+	}
 	// get the message
 	msg, err := FromAny(request.Tx.Msg)
 	if err != nil {
@@ -61,14 +91,54 @@ func (c *Contract) CheckTx(request *PluginCheckRequest) *PluginCheckResponse {
 	// handle the message
 	switch x := msg.(type) {
 	case *MessageSend:
-		return c.CheckMessageSend(x)
+		return c.CheckMessageSend(ctx, x)
+	case *MessageSendOnBehalf:
+		return c.CheckMessageSendOnBehalf(ctx, x)
+	case *MessageWithdrawFromReserve:
+		return c.CheckMessageWithdrawFromReserve(ctx, x)
+	case *MessageMultiSend:
+		return c.CheckMessageMultiSend(ctx, x)
+	case *MessageGrantFeeAllowance:
+		return c.CheckMessageGrantFeeAllowance(ctx, x)
 	default:
 		return &PluginCheckResponse{Error: ErrInvalidMessageCast()}
 	}
 }
 
+// loadFeeParams() reads the governance fee floor, skipping the read in Mempool mode when a copy was cached since
+// the current block began (BeginBlock clears the cache)
+func (c *Contract) loadFeeParams(ctx context.Context, mode ExecMode) (*FeeParams, error) {
+	if mode == Mempool && c.feeParams != nil {
+		return c.feeParams, nil
+	}
+	resp, err := c.plugin.StateRead(ctx, c, &PluginStateReadRequest{
+		Keys: []*PluginKeyRead{
+			{QueryId: rand.Uint64(), Key: KeyForFeeParams()},
+		}})
+	if err == nil {
+		err = resp.Error
+	}
+	if err == nil && ctx.Err() != nil {
+		err = ErrExecTimeout()
+	}
+	if err != nil {
+		return nil, err
+	}
+	minFees := new(FeeParams)
+	if err = Unmarshal(resp.Results[0].Entries[0].Value, minFees); err != nil {
+		return nil, err
+	}
+	c.feeParams = minFees
+	return minFees, nil
+}
+
 // DeliverTx() is code that is executed to apply a transaction
 func (c *Contract) DeliverTx(request *PluginDeliverRequest) *PluginDeliverResponse {
+	ctx, cancel := execContext(request.ExecMode, request.Deadline)
+	defer cancel()
+	// count this tx's gas and fee against the block's totals, regardless of message type or outcome
+	c.blockGasUsed += request.Tx.GasWanted
+	c.blockFees += request.Tx.Fee
 	// get the message
 	msg, err := FromAny(request.Tx.Msg)
 	if err != nil {
@@ -77,37 +147,75 @@ func (c *Contract) DeliverTx(request *PluginDeliverRequest) *PluginDeliverRespon
 	// handle the message
 	switch x := msg.(type) {
 	case *MessageSend:
-		return c.DeliverMessageSend(x)
+		return c.DeliverMessageSend(ctx, x, request.Tx.Fee)
+	case *MessageSendOnBehalf:
+		return c.DeliverMessageSendOnBehalf(ctx, x, request.Tx.Fee)
+	case *MessageWithdrawFromReserve:
+		return c.DeliverMessageWithdrawFromReserve(ctx, x)
+	case *MessageMultiSend:
+		return c.DeliverMessageMultiSend(ctx, x, request.Tx.Fee)
+	case *MessageGrantFeeAllowance:
+		return c.DeliverMessageGrantFeeAllowance(ctx, x)
 	default:
 		return &PluginDeliverResponse{Error: ErrInvalidMessageCast()}
 	}
 }
 
 // EndBlock() is code that is executed at the end of 'applying' a block
-func (c *Contract) EndBlock(_ *PluginEndRequest) *PluginEndResponse {
-	return &PluginEndResponse{}
+func (c *Contract) EndBlock(request *PluginEndRequest) *PluginEndResponse {
+	ctx, cancel := execContext(request.ExecMode, request.Deadline)
+	defer cancel()
+	// settle this block's collected fees to the reserve pool / proposer / burn sink before moving the base fee
+	if resp := c.settleFeeDistribution(ctx); resp.Error != nil {
+		return resp
+	}
+	// recompute and persist the dynamic base fee from this block's gas utilization
+	return c.settleBaseFee(ctx)
 }
 
 // CheckMessageSend() statelessly validates a 'send' message
-func (c *Contract) CheckMessageSend(msg *MessageSend) *PluginCheckResponse {
+func (c *Contract) CheckMessageSend(ctx context.Context, msg *MessageSend) *PluginCheckResponse {
 	// check sender address
 	if len(msg.FromAddress) != 20 {
 		return &PluginCheckResponse{Error: ErrInvalidAddress()}
 	}
 	// check recipient address
-	if len(msg.FromAddress) != 20 {
+	if len(msg.ToAddress) != 20 {
 		return &PluginCheckResponse{Error: ErrInvalidAddress()}
 	}
 	// check amount
 	if msg.Amount == 0 {
 		return &PluginCheckResponse{Error: ErrInvalidAmount()}
 	}
+	// check the sequence against the sender's current on-chain sequence, to reject replays
+	resp, err := c.plugin.StateRead(ctx, c, &PluginStateReadRequest{
+		Keys: []*PluginKeyRead{
+			{QueryId: rand.Uint64(), Key: KeyForAccount(msg.FromAddress)},
+		}})
+	if err == nil {
+		err = resp.Error
+	}
+	if err == nil && ctx.Err() != nil {
+		err = ErrExecTimeout()
+	}
+	if err != nil {
+		return &PluginCheckResponse{Error: err}
+	}
+	from := new(Account)
+	if err = Unmarshal(resp.Results[0].Entries[0].Value, from); err != nil {
+		return &PluginCheckResponse{Error: err}
+	}
+	if msg.Sequence != from.Sequence+1 {
+		return &PluginCheckResponse{Error: ErrInvalidSequence()}
+	}
 	// return the authorized signers
 	return &PluginCheckResponse{AuthorizedSigners: [][]byte{msg.FromAddress}}
 }
 
-// DeliverMessageSend() handles a 'send' message
-func (c *Contract) DeliverMessageSend(msg *MessageSend) *PluginDeliverResponse {
+// DeliverMessageSend() handles a 'send' message, debiting Amount plus Tx.Fee from the sender before crediting
+// the recipient with Amount; the fee itself is not credited anywhere here, since DeliverTx already folds it into
+// c.blockFees for EndBlock's settleFeeDistribution to split between the reserve pool, the proposer, and burn
+func (c *Contract) DeliverMessageSend(ctx context.Context, msg *MessageSend, fee uint64) *PluginDeliverResponse {
 	var (
 		fromKey, toKey         []byte
 		fromBytes, toBytes     []byte
@@ -117,7 +225,7 @@ func (c *Contract) DeliverMessageSend(msg *MessageSend) *PluginDeliverResponse {
 	// calculate the from key and to key
 	fromKey, toKey = KeyForAccount(msg.FromAddress), KeyForAccount(msg.ToAddress)
 	// get the from and to account
-	response, err := c.plugin.StateRead(c, &PluginStateReadRequest{
+	response, err := c.plugin.StateRead(ctx, c, &PluginStateReadRequest{
 		Keys: []*PluginKeyRead{
 			{QueryId: fromQueryId, Key: fromKey},
 			{QueryId: toQueryId, Key: toKey},
@@ -128,7 +236,10 @@ func (c *Contract) DeliverMessageSend(msg *MessageSend) *PluginDeliverResponse {
 	}
 	// ensure no error fsm error
 	if response.Error != nil {
-		return &PluginDeliverResponse{Error: err}
+		return &PluginDeliverResponse{Error: response.Error}
+	}
+	if ctx.Err() != nil {
+		return &PluginDeliverResponse{Error: ErrExecTimeout()}
 	}
 	// get the from bytes and to bytes
 	for _, resp := range response.Results {
@@ -145,20 +256,36 @@ func (c *Contract) DeliverMessageSend(msg *MessageSend) *PluginDeliverResponse {
 	if err = Unmarshal(toBytes, to); err != nil {
 		return &PluginDeliverResponse{Error: err}
 	}
-	// if the account amount is less than the amount to subtract; return insufficient funds
-	if from.Amount < msg.Amount {
-		return &PluginDeliverResponse{Error: ErrInsufficientFunds()}
+	// if the account amount is less than the amount plus fee to subtract; bump the sequence (so the tx can't be
+	// replayed) and return insufficient funds without moving any balance
+	if from.Amount < msg.Amount+fee {
+		from.Sequence++
+		if fromBytes, err = Marshal(from); err != nil {
+			return &PluginDeliverResponse{Error: err}
+		}
+		resp, err := c.plugin.StateWrite(ctx, c, &PluginStateWriteRequest{
+			Sets: []*PluginSetOp{{Key: fromKey, Value: fromBytes}},
+		})
+		if err == nil {
+			err = resp.Error
+		}
+		if err == nil {
+			err = ErrInsufficientFunds()
+		}
+		return &PluginDeliverResponse{Error: err}
 	}
-	// subtract from sender
-	from.Amount -= msg.Amount
+	// subtract the transferred amount and the tx fee from sender
+	from.Amount -= msg.Amount + fee
 	// add to recipient
 	to.Amount += msg.Amount
+	// bump the sender's sequence in the same write batch that moves the balances
+	from.Sequence++
 	// convert the accounts to bytes
 	fromBytes, err = Marshal(from)
 	if err != nil {
 		return &PluginDeliverResponse{Error: err}
 	}
-	toBytes, err = Marshal(from)
+	toBytes, err = Marshal(to)
 	if err != nil {
 		return &PluginDeliverResponse{Error: err}
 	}
@@ -166,12 +293,12 @@ func (c *Contract) DeliverMessageSend(msg *MessageSend) *PluginDeliverResponse {
 	var resp *PluginStateWriteResponse
 	// if the from account is drained - delete the from account
 	if from.Amount == 0 {
-		resp, err = c.plugin.StateWrite(c, &PluginStateWriteRequest{
+		resp, err = c.plugin.StateWrite(ctx, c, &PluginStateWriteRequest{
 			Sets:    []*PluginSetOp{{Key: toKey, Value: toBytes}},
 			Deletes: []*PluginDeleteOp{{Key: fromKey}},
 		})
 	} else {
-		resp, err = c.plugin.StateWrite(c, &PluginStateWriteRequest{
+		resp, err = c.plugin.StateWrite(ctx, c, &PluginStateWriteRequest{
 			Sets: []*PluginSetOp{{Key: toKey, Value: toBytes}, {Key: fromKey, Value: fromBytes}},
 		})
 	}
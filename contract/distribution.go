@@ -0,0 +1,192 @@
+package contract
+
+import (
+	"context"
+	"math/rand"
+)
+
+/* This file adds a reserve pool and governance-configurable fee distribution, so collected fees are routed
+   to the reserve pool, the block proposer, and a burn sink instead of being silently discarded */
+
+// DistributionParams: governance controlled weights for splitting a block's collected fees
+type DistributionParams struct {
+	ReservePoolBps    uint64   // basis points of each block's fees routed to the reserve pool
+	ProposerBps       uint64   // basis points of each block's fees routed to the proposer
+	BurnBps           uint64   // basis points of each block's fees burned (not credited to any account)
+	AuthorizedSigners [][]byte // addresses permitted to submit MessageWithdrawFromReserve
+}
+
+// ReservePoolAddress: the reserved 20-byte account address the reserve pool's balance is held under
+var ReservePoolAddress = []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}
+
+// KeyForDistributionParams() returns the state database key for governance controlled fee distribution weights
+func KeyForDistributionParams() []byte {
+	return JoinLenPrefix(paramsPrefix, []byte("/d/"))
+}
+
+// settleFeeDistribution() credits this block's accumulated fees to the reserve pool and proposer per
+// DistributionParams, in a single batched StateWrite; the burned share is simply never credited
+func (c *Contract) settleFeeDistribution(ctx context.Context) *PluginEndResponse {
+	if c.blockFees == 0 {
+		return &PluginEndResponse{}
+	}
+	var (
+		paramsQ, reserveQ, proposerQ             = rand.Uint64(), rand.Uint64(), rand.Uint64()
+		paramsBytes, reserveBytes, proposerBytes []byte
+	)
+	resp, err := c.plugin.StateRead(ctx, c, &PluginStateReadRequest{
+		Keys: []*PluginKeyRead{
+			{QueryId: paramsQ, Key: KeyForDistributionParams()},
+			{QueryId: reserveQ, Key: KeyForAccount(ReservePoolAddress)},
+			{QueryId: proposerQ, Key: KeyForAccount(c.proposer)},
+		}})
+	if err == nil {
+		err = resp.Error
+	}
+	if err == nil && ctx.Err() != nil {
+		err = ErrExecTimeout()
+	}
+	if err != nil {
+		return &PluginEndResponse{Error: err}
+	}
+	for _, r := range resp.Results {
+		switch r.QueryId {
+		case paramsQ:
+			paramsBytes = r.Entries[0].Value
+		case reserveQ:
+			reserveBytes = r.Entries[0].Value
+		case proposerQ:
+			proposerBytes = r.Entries[0].Value
+		}
+	}
+	params, reserve, proposer := new(DistributionParams), new(Account), new(Account)
+	if err = Unmarshal(paramsBytes, params); err != nil {
+		return &PluginEndResponse{Error: err}
+	}
+	if err = Unmarshal(reserveBytes, reserve); err != nil {
+		return &PluginEndResponse{Error: err}
+	}
+	if err = Unmarshal(proposerBytes, proposer); err != nil {
+		return &PluginEndResponse{Error: err}
+	}
+	// split the block's fees by basis-point weight; the burned remainder is never credited to any account
+	reserve.Amount += c.blockFees * params.ReservePoolBps / 10000
+	proposer.Amount += c.blockFees * params.ProposerBps / 10000
+	reserveBytes, err = Marshal(reserve)
+	if err != nil {
+		return &PluginEndResponse{Error: err}
+	}
+	proposerBytes, err = Marshal(proposer)
+	if err != nil {
+		return &PluginEndResponse{Error: err}
+	}
+	writeResp, err := c.plugin.StateWrite(ctx, c, &PluginStateWriteRequest{
+		Sets: []*PluginSetOp{
+			{Key: KeyForAccount(ReservePoolAddress), Value: reserveBytes},
+			{Key: KeyForAccount(c.proposer), Value: proposerBytes},
+		}})
+	if err == nil {
+		err = writeResp.Error
+	}
+	return &PluginEndResponse{Error: err}
+}
+
+// MessageWithdrawFromReserve: governance-authorized withdrawal from the reserve pool
+type MessageWithdrawFromReserve struct {
+	Signer    []byte // must appear in DistributionParams.AuthorizedSigners
+	ToAddress []byte // account credited the withdrawn amount
+	Amount    uint64 // amount withdrawn from the reserve pool
+}
+
+// CheckMessageWithdrawFromReserve() statelessly validates a 'withdraw_from_reserve' message
+func (c *Contract) CheckMessageWithdrawFromReserve(ctx context.Context, msg *MessageWithdrawFromReserve) *PluginCheckResponse {
+	if ctx.Err() != nil {
+		return &PluginCheckResponse{Error: ErrExecTimeout()}
+	}
+	if len(msg.Signer) != 20 {
+		return &PluginCheckResponse{Error: ErrInvalidAddress()}
+	}
+	if len(msg.ToAddress) != 20 {
+		return &PluginCheckResponse{Error: ErrInvalidAddress()}
+	}
+	if msg.Amount == 0 {
+		return &PluginCheckResponse{Error: ErrInvalidAmount()}
+	}
+	return &PluginCheckResponse{AuthorizedSigners: [][]byte{msg.Signer}}
+}
+
+// DeliverMessageWithdrawFromReserve() handles a 'withdraw_from_reserve' message
+func (c *Contract) DeliverMessageWithdrawFromReserve(ctx context.Context, msg *MessageWithdrawFromReserve) *PluginDeliverResponse {
+	var (
+		paramsQ, reserveQ, toQ             = rand.Uint64(), rand.Uint64(), rand.Uint64()
+		paramsBytes, reserveBytes, toBytes []byte
+	)
+	resp, err := c.plugin.StateRead(ctx, c, &PluginStateReadRequest{
+		Keys: []*PluginKeyRead{
+			{QueryId: paramsQ, Key: KeyForDistributionParams()},
+			{QueryId: reserveQ, Key: KeyForAccount(ReservePoolAddress)},
+			{QueryId: toQ, Key: KeyForAccount(msg.ToAddress)},
+		}})
+	if err == nil {
+		err = resp.Error
+	}
+	if err == nil && ctx.Err() != nil {
+		err = ErrExecTimeout()
+	}
+	if err != nil {
+		return &PluginDeliverResponse{Error: err}
+	}
+	for _, r := range resp.Results {
+		switch r.QueryId {
+		case paramsQ:
+			paramsBytes = r.Entries[0].Value
+		case reserveQ:
+			reserveBytes = r.Entries[0].Value
+		case toQ:
+			toBytes = r.Entries[0].Value
+		}
+	}
+	params, reserve, to := new(DistributionParams), new(Account), new(Account)
+	if err = Unmarshal(paramsBytes, params); err != nil {
+		return &PluginDeliverResponse{Error: err}
+	}
+	if err = Unmarshal(reserveBytes, reserve); err != nil {
+		return &PluginDeliverResponse{Error: err}
+	}
+	if err = Unmarshal(toBytes, to); err != nil {
+		return &PluginDeliverResponse{Error: err}
+	}
+	// only an address governance has placed in AuthorizedSigners may drain the reserve pool
+	authorized := false
+	for _, signer := range params.AuthorizedSigners {
+		if string(signer) == string(msg.Signer) {
+			authorized = true
+			break
+		}
+	}
+	if !authorized {
+		return &PluginDeliverResponse{Error: ErrUnauthorizedSigner()}
+	}
+	if reserve.Amount < msg.Amount {
+		return &PluginDeliverResponse{Error: ErrInsufficientFunds()}
+	}
+	reserve.Amount -= msg.Amount
+	to.Amount += msg.Amount
+	reserveBytes, err = Marshal(reserve)
+	if err != nil {
+		return &PluginDeliverResponse{Error: err}
+	}
+	toBytes, err = Marshal(to)
+	if err != nil {
+		return &PluginDeliverResponse{Error: err}
+	}
+	writeResp, err := c.plugin.StateWrite(ctx, c, &PluginStateWriteRequest{
+		Sets: []*PluginSetOp{
+			{Key: KeyForAccount(ReservePoolAddress), Value: reserveBytes},
+			{Key: KeyForAccount(msg.ToAddress), Value: toBytes},
+		}})
+	if err == nil {
+		err = writeResp.Error
+	}
+	return &PluginDeliverResponse{Error: err}
+}
@@ -0,0 +1,42 @@
+package contract
+
+import (
+	"context"
+	"time"
+)
+
+/* This file models the plugin's execution mode so block production can enforce a deadline without imposing
+   the same constraint on validators replaying finalized blocks or on mempool admission */
+
+// ExecMode identifies which role is currently driving a plugin call
+type ExecMode int
+
+const (
+	_            ExecMode = iota
+	BlockFactory          // producing a new block; strict wall-clock deadlines are enforced
+	ChainService          // replaying/validating an already-finalized block; no deadline is enforced
+	Mempool               // admitting a tx into the mempool; may short-circuit expensive stateless checks
+)
+
+// String() implements fmt.Stringer
+func (m ExecMode) String() string {
+	switch m {
+	case BlockFactory:
+		return "block_factory"
+	case ChainService:
+		return "chain_service"
+	case Mempool:
+		return "mempool"
+	default:
+		return "unknown"
+	}
+}
+
+// execContext() builds the context used for the plugin state IO of a single call: BlockFactory mode carries a
+// hard deadline so a slow block producer can't stall on one tx; other modes run without a deadline
+func execContext(mode ExecMode, deadline time.Duration) (context.Context, context.CancelFunc) {
+	if mode == BlockFactory && deadline > 0 {
+		return context.WithTimeout(context.Background(), deadline)
+	}
+	return context.Background(), func() {}
+}
@@ -0,0 +1,99 @@
+package contract
+
+import (
+	"context"
+	"math/rand"
+)
+
+/* This file adds an EIP-1559-style dynamic base fee on top of the static 'send' fee floor in contract.go */
+
+// GasParams: governance controlled parameters (and derived state) for the dynamic base fee mechanism
+type GasParams struct {
+	TargetGasPerBlock uint64   // desired average gas usage per block
+	WindowSize        uint64   // number of trailing blocks averaged to measure utilization
+	MaxAdjustmentPct  uint64   // max base fee move per block, in basis points, applied proportionally to the utilization delta
+	MinBaseFeePerGas  uint64   // floor the base fee may never drop below
+	BaseFeePerGas     uint64   // the currently active base fee, charged per unit of GasWanted
+	GasUsedWindow     []uint64 // ring buffer of the last WindowSize blocks' gas used
+	WindowCursor      uint64   // index of the next slot to overwrite once the ring buffer is full
+}
+
+// KeyForGasParams() returns the state database key for the dynamic base fee parameters
+func KeyForGasParams() []byte {
+	return JoinLenPrefix(paramsPrefix, []byte("/g/"))
+}
+
+// settleBaseFee() pushes the current block's gas usage into the rolling window and recomputes BaseFeePerGas
+func (c *Contract) settleBaseFee(ctx context.Context) *PluginEndResponse {
+	gasParams := c.gasParams
+	if gasParams == nil {
+		gasParams = new(GasParams)
+	}
+	// push this block's gas usage into the rolling window, wrapping once WindowSize is reached
+	if gasParams.WindowSize != 0 && uint64(len(gasParams.GasUsedWindow)) >= gasParams.WindowSize {
+		gasParams.GasUsedWindow[gasParams.WindowCursor%gasParams.WindowSize] = c.blockGasUsed
+	} else {
+		gasParams.GasUsedWindow = append(gasParams.GasUsedWindow, c.blockGasUsed)
+	}
+	gasParams.WindowCursor++
+	// average utilization over the window, then move the base fee towards the target, clamped by MaxAdjustmentPct
+	if gasParams.TargetGasPerBlock != 0 && len(gasParams.GasUsedWindow) != 0 {
+		var total uint64
+		for _, used := range gasParams.GasUsedWindow {
+			total += used
+		}
+		avg := total / uint64(len(gasParams.GasUsedWindow))
+		switch {
+		case avg > gasParams.TargetGasPerBlock:
+			excess := avg - gasParams.TargetGasPerBlock
+			if excess > gasParams.TargetGasPerBlock {
+				excess = gasParams.TargetGasPerBlock
+			}
+			delta := gasParams.BaseFeePerGas * gasParams.MaxAdjustmentPct * excess / gasParams.TargetGasPerBlock / 10000
+			gasParams.BaseFeePerGas += delta
+		case avg < gasParams.TargetGasPerBlock:
+			delta := gasParams.BaseFeePerGas * gasParams.MaxAdjustmentPct * (gasParams.TargetGasPerBlock - avg) / gasParams.TargetGasPerBlock / 10000
+			if delta > gasParams.BaseFeePerGas-gasParams.MinBaseFeePerGas {
+				delta = gasParams.BaseFeePerGas - gasParams.MinBaseFeePerGas
+			}
+			gasParams.BaseFeePerGas -= delta
+		}
+	}
+	if gasParams.BaseFeePerGas < gasParams.MinBaseFeePerGas {
+		gasParams.BaseFeePerGas = gasParams.MinBaseFeePerGas
+	}
+	// persist the updated window and base fee so replaying this block produces the same result
+	bz, err := Marshal(gasParams)
+	if err != nil {
+		return &PluginEndResponse{Error: err}
+	}
+	resp, err := c.plugin.StateWrite(ctx, c, &PluginStateWriteRequest{
+		Sets: []*PluginSetOp{{Key: KeyForGasParams(), Value: bz}},
+	})
+	if err == nil {
+		err = resp.Error
+	}
+	if err == nil && ctx.Err() != nil {
+		err = ErrExecTimeout()
+	}
+	return &PluginEndResponse{Error: err}
+}
+
+// QueryBaseFee() handles a PluginQueryBaseFee request so wallets can price a transaction before submitting it
+func (c *Contract) QueryBaseFee(_ *PluginQueryBaseFeeRequest) *PluginQueryBaseFeeResponse {
+	resp, err := c.plugin.StateRead(context.Background(), c, &PluginStateReadRequest{
+		Keys: []*PluginKeyRead{
+			{QueryId: rand.Uint64(), Key: KeyForGasParams()},
+		}})
+	if err == nil {
+		err = resp.Error
+	}
+	if err != nil {
+		return &PluginQueryBaseFeeResponse{Error: err}
+	}
+	gasParams := new(GasParams)
+	if err = Unmarshal(resp.Results[0].Entries[0].Value, gasParams); err != nil {
+		return &PluginQueryBaseFeeResponse{Error: err}
+	}
+	return &PluginQueryBaseFeeResponse{BaseFeePerGas: gasParams.BaseFeePerGas}
+}
@@ -0,0 +1,179 @@
+package contract
+
+import (
+	"context"
+	"math/rand"
+)
+
+/* This file adds a batched 'multi_send' message: many inputs and many outputs settled in a single atomic
+   StateRead / StateWrite pair, instead of one MessageSend per transfer leg */
+
+// MessageMultiSend: a sum-balanced batch of debits (Inputs) and credits (Outputs) settled atomically
+type MessageMultiSend struct {
+	Inputs  []*MultiSendInput  // accounts debited, with the amount debited from each
+	Outputs []*MultiSendOutput // accounts credited, with the amount credited to each
+}
+
+// MultiSendInput: one debited leg of a MessageMultiSend
+type MultiSendInput struct {
+	Address  []byte
+	Amount   uint64
+	Sequence uint64 // must equal the account's current on-chain sequence + 1, to reject replays
+}
+
+// MultiSendOutput: one credited leg of a MessageMultiSend
+type MultiSendOutput struct {
+	Address []byte
+	Amount  uint64
+}
+
+// CheckMessageMultiSend() statelessly validates a 'multi_send' message
+func (c *Contract) CheckMessageMultiSend(ctx context.Context, msg *MessageMultiSend) *PluginCheckResponse {
+	if len(msg.Inputs) == 0 || len(msg.Outputs) == 0 {
+		return &PluginCheckResponse{Error: ErrInvalidAmount()}
+	}
+	var (
+		inTotal, outTotal uint64
+		keys              = make([]*PluginKeyRead, len(msg.Inputs))
+		queryIdFor        = make(map[uint64][]byte, len(msg.Inputs))
+		signers           = make([][]byte, 0, len(msg.Inputs))
+	)
+	for i, in := range msg.Inputs {
+		if len(in.Address) != 20 {
+			return &PluginCheckResponse{Error: ErrInvalidAddress()}
+		}
+		if in.Amount == 0 {
+			return &PluginCheckResponse{Error: ErrInvalidAmount()}
+		}
+		inTotal += in.Amount
+		signers = append(signers, in.Address)
+		queryId := rand.Uint64()
+		queryIdFor[queryId] = in.Address
+		keys[i] = &PluginKeyRead{QueryId: queryId, Key: KeyForAccount(in.Address)}
+	}
+	for _, out := range msg.Outputs {
+		if len(out.Address) != 20 {
+			return &PluginCheckResponse{Error: ErrInvalidAddress()}
+		}
+		if out.Amount == 0 {
+			return &PluginCheckResponse{Error: ErrInvalidAmount()}
+		}
+		outTotal += out.Amount
+	}
+	// the batch must be sum-balanced: every unit debited from an input must be credited to an output
+	if inTotal != outTotal {
+		return &PluginCheckResponse{Error: ErrInvalidAmount()}
+	}
+	// check every input's sequence against its sender's current on-chain sequence, to reject replays
+	resp, err := c.plugin.StateRead(ctx, c, &PluginStateReadRequest{Keys: keys})
+	if err == nil {
+		err = resp.Error
+	}
+	if err == nil && ctx.Err() != nil {
+		err = ErrExecTimeout()
+	}
+	if err != nil {
+		return &PluginCheckResponse{Error: err}
+	}
+	addrToSeq := make(map[string]uint64, len(msg.Inputs))
+	for _, in := range msg.Inputs {
+		addrToSeq[string(in.Address)] = in.Sequence
+	}
+	for _, result := range resp.Results {
+		account := new(Account)
+		if err = Unmarshal(result.Entries[0].Value, account); err != nil {
+			return &PluginCheckResponse{Error: err}
+		}
+		if addrToSeq[string(queryIdFor[result.QueryId])] != account.Sequence+1 {
+			return &PluginCheckResponse{Error: ErrInvalidSequence()}
+		}
+	}
+	// every input account must authorize its own debit
+	return &PluginCheckResponse{AuthorizedSigners: signers}
+}
+
+// DeliverMessageMultiSend() handles a 'multi_send' message: a single StateRead loads every touched account, the
+// debits and credits are applied in memory, and a single StateWrite commits all of them so the batch is atomic.
+// The first input is treated as the tx's fee payer, mirroring DeliverMessageSend's sender-pays-fee convention
+func (c *Contract) DeliverMessageMultiSend(ctx context.Context, msg *MessageMultiSend, fee uint64) *PluginDeliverResponse {
+	var (
+		keys       = make([]*PluginKeyRead, 0, len(msg.Inputs)+len(msg.Outputs))
+		queryIdFor = make(map[string]uint64, len(msg.Inputs)+len(msg.Outputs))
+		accounts   = make(map[string]*Account, len(msg.Inputs)+len(msg.Outputs))
+	)
+	// collect one read per distinct address, using distinct QueryIds the same way DeliverMessageSend does for from/to
+	addAddress := func(addr []byte) {
+		addrStr := string(addr)
+		if _, ok := queryIdFor[addrStr]; ok {
+			return
+		}
+		queryId := rand.Uint64()
+		queryIdFor[addrStr] = queryId
+		keys = append(keys, &PluginKeyRead{QueryId: queryId, Key: KeyForAccount(addr)})
+	}
+	for _, in := range msg.Inputs {
+		addAddress(in.Address)
+	}
+	for _, out := range msg.Outputs {
+		addAddress(out.Address)
+	}
+	response, err := c.plugin.StateRead(ctx, c, &PluginStateReadRequest{Keys: keys})
+	if err != nil {
+		return &PluginDeliverResponse{Error: err}
+	}
+	if response.Error != nil {
+		return &PluginDeliverResponse{Error: response.Error}
+	}
+	if ctx.Err() != nil {
+		return &PluginDeliverResponse{Error: ErrExecTimeout()}
+	}
+	// map each result back to its address via QueryId, then unmarshal into the in-memory account set
+	queryIdToAddr := make(map[uint64]string, len(queryIdFor))
+	for addrStr, queryId := range queryIdFor {
+		queryIdToAddr[queryId] = addrStr
+	}
+	for _, result := range response.Results {
+		account := new(Account)
+		if err = Unmarshal(result.Entries[0].Value, account); err != nil {
+			return &PluginDeliverResponse{Error: err}
+		}
+		accounts[queryIdToAddr[result.QueryId]] = account
+	}
+	// debit every input, failing the whole batch if any one account has insufficient funds, and bump each
+	// input's sequence so this exact batch can't be replayed; the first input additionally covers Tx.Fee
+	for i, in := range msg.Inputs {
+		debit := in.Amount
+		if i == 0 {
+			debit += fee
+		}
+		account := accounts[string(in.Address)]
+		if account.Amount < debit {
+			return &PluginDeliverResponse{Error: ErrInsufficientFunds()}
+		}
+		account.Amount -= debit
+		account.Sequence++
+	}
+	// credit every output
+	for _, out := range msg.Outputs {
+		accounts[string(out.Address)].Amount += out.Amount
+	}
+	// build a single StateWrite: accounts with no balance and no sequence are deleted, everything else is set; a
+	// drained input keeps its bumped sequence, so deleting it would reopen this batch to replay
+	write := new(PluginStateWriteRequest)
+	for addrStr, account := range accounts {
+		if account.Amount == 0 && account.Sequence == 0 {
+			write.Deletes = append(write.Deletes, &PluginDeleteOp{Key: KeyForAccount([]byte(addrStr))})
+			continue
+		}
+		bz, err := Marshal(account)
+		if err != nil {
+			return &PluginDeliverResponse{Error: err}
+		}
+		write.Sets = append(write.Sets, &PluginSetOp{Key: KeyForAccount([]byte(addrStr)), Value: bz})
+	}
+	writeResp, err := c.plugin.StateWrite(ctx, c, write)
+	if err == nil {
+		err = writeResp.Error
+	}
+	return &PluginDeliverResponse{Error: err}
+}
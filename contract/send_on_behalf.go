@@ -0,0 +1,219 @@
+package contract
+
+import (
+	"context"
+	"math/rand"
+)
+
+/* This file adds meta-transaction support: a third-party FeePayer may cover the fee for a sender with no balance */
+
+// MessageSendOnBehalf: a 'send' where FeePayer covers Tx.Fee instead of FromAddress
+type MessageSendOnBehalf struct {
+	FromAddress []byte // the account whose balance is debited the Amount
+	ToAddress   []byte // the account credited the Amount
+	Amount      uint64 // the amount transferred from FromAddress to ToAddress
+	Sequence    uint64 // must equal FromAddress's current on-chain sequence + 1, to reject replays
+	FeePayer    []byte // the account debited Tx.Fee; authorization comes from AuthorizedSigners like any other message
+}
+
+// FeeAllowance: governance-free, payer-granted cap on how much a FeePayer will cover for a grantee
+type FeeAllowance struct {
+	Limit uint64 // maximum cumulative fee the payer will cover for the grantee this epoch
+	Spent uint64 // cumulative fee already covered this epoch
+	Epoch uint64 // the epoch this allowance was last reset for
+}
+
+// AllowanceParams: governance controlled parameters for the fee allowance epoch
+type AllowanceParams struct {
+	EpochLength uint64 // number of blocks per fee-allowance epoch; Spent rolls back to 0 once the epoch advances
+}
+
+var feeAllowancePrefix = []byte{8} // store key prefix for fee allowances
+
+// KeyForFeeAllowance() returns the state database key for the allowance a payer has granted a grantee
+func KeyForFeeAllowance(payer, grantee []byte) []byte {
+	return JoinLenPrefix(feeAllowancePrefix, payer, grantee)
+}
+
+// KeyForAllowanceParams() returns the state database key for the fee allowance epoch length
+func KeyForAllowanceParams() []byte {
+	return JoinLenPrefix(paramsPrefix, []byte("/a/"))
+}
+
+// currentEpoch() derives the fee allowance epoch from the height of the block currently being applied
+func (c *Contract) currentEpoch(params *AllowanceParams) uint64 {
+	if params.EpochLength == 0 {
+		return 0
+	}
+	return c.height / params.EpochLength
+}
+
+// CheckMessageSendOnBehalf() statelessly validates a 'send_on_behalf' message
+func (c *Contract) CheckMessageSendOnBehalf(ctx context.Context, msg *MessageSendOnBehalf) *PluginCheckResponse {
+	// check sender address
+	if len(msg.FromAddress) != 20 {
+		return &PluginCheckResponse{Error: ErrInvalidAddress()}
+	}
+	// check recipient address
+	if len(msg.ToAddress) != 20 {
+		return &PluginCheckResponse{Error: ErrInvalidAddress()}
+	}
+	// check fee payer address
+	if len(msg.FeePayer) != 20 {
+		return &PluginCheckResponse{Error: ErrInvalidAddress()}
+	}
+	// check amount
+	if msg.Amount == 0 {
+		return &PluginCheckResponse{Error: ErrInvalidAmount()}
+	}
+	// check the sequence against the sender's current on-chain sequence, to reject replays
+	resp, err := c.plugin.StateRead(ctx, c, &PluginStateReadRequest{
+		Keys: []*PluginKeyRead{
+			{QueryId: rand.Uint64(), Key: KeyForAccount(msg.FromAddress)},
+		}})
+	if err == nil {
+		err = resp.Error
+	}
+	if err == nil && ctx.Err() != nil {
+		err = ErrExecTimeout()
+	}
+	if err != nil {
+		return &PluginCheckResponse{Error: err}
+	}
+	from := new(Account)
+	if err = Unmarshal(resp.Results[0].Entries[0].Value, from); err != nil {
+		return &PluginCheckResponse{Error: err}
+	}
+	if msg.Sequence != from.Sequence+1 {
+		return &PluginCheckResponse{Error: ErrInvalidSequence()}
+	}
+	// both the sender and the fee payer must authorize this tx
+	return &PluginCheckResponse{AuthorizedSigners: [][]byte{msg.FromAddress, msg.FeePayer}}
+}
+
+// DeliverMessageSendOnBehalf() handles a 'send_on_behalf' message, debiting fee from FeePayer before transferring
+func (c *Contract) DeliverMessageSendOnBehalf(ctx context.Context, msg *MessageSendOnBehalf, fee uint64) *PluginDeliverResponse {
+	var (
+		payerKey, allowanceKey, paramsKey   = KeyForAccount(msg.FeePayer), KeyForFeeAllowance(msg.FeePayer, msg.FromAddress), KeyForAllowanceParams()
+		payerQueryId, allowQueryId, paramsQ = rand.Uint64(), rand.Uint64(), rand.Uint64()
+		payer, allowance, params            = new(Account), new(FeeAllowance), new(AllowanceParams)
+		payerBytes, allowBytes, paramsBytes []byte
+	)
+	// load the fee payer's account, their allowance for this grantee, and the epoch length governing it
+	response, err := c.plugin.StateRead(ctx, c, &PluginStateReadRequest{
+		Keys: []*PluginKeyRead{
+			{QueryId: payerQueryId, Key: payerKey},
+			{QueryId: allowQueryId, Key: allowanceKey},
+			{QueryId: paramsQ, Key: paramsKey},
+		}})
+	if err != nil {
+		return &PluginDeliverResponse{Error: err}
+	}
+	if response.Error != nil {
+		return &PluginDeliverResponse{Error: response.Error}
+	}
+	if ctx.Err() != nil {
+		return &PluginDeliverResponse{Error: ErrExecTimeout()}
+	}
+	for _, resp := range response.Results {
+		switch resp.QueryId {
+		case payerQueryId:
+			payerBytes = resp.Entries[0].Value
+		case allowQueryId:
+			allowBytes = resp.Entries[0].Value
+		case paramsQ:
+			paramsBytes = resp.Entries[0].Value
+		}
+	}
+	if err = Unmarshal(payerBytes, payer); err != nil {
+		return &PluginDeliverResponse{Error: err}
+	}
+	if err = Unmarshal(allowBytes, allowance); err != nil {
+		return &PluginDeliverResponse{Error: err}
+	}
+	if err = Unmarshal(paramsBytes, params); err != nil {
+		return &PluginDeliverResponse{Error: err}
+	}
+	// roll the allowance over to the current epoch before checking or spending it
+	if epoch := c.currentEpoch(params); allowance.Epoch != epoch {
+		allowance.Epoch, allowance.Spent = epoch, 0
+	}
+	// enforce the payer's self-imposed cap on how much they'll cover for this grantee
+	if allowance.Spent+fee > allowance.Limit {
+		return &PluginDeliverResponse{Error: ErrFeeAllowanceExceeded()}
+	}
+	// the payer must be able to afford the fee
+	if payer.Amount < fee {
+		return &PluginDeliverResponse{Error: ErrInsufficientFunds()}
+	}
+	payer.Amount -= fee
+	allowance.Spent += fee
+	if payerBytes, err = Marshal(payer); err != nil {
+		return &PluginDeliverResponse{Error: err}
+	}
+	if allowBytes, err = Marshal(allowance); err != nil {
+		return &PluginDeliverResponse{Error: err}
+	}
+	// debit the fee payer and their allowance before running the underlying transfer
+	writeResp, err := c.plugin.StateWrite(ctx, c, &PluginStateWriteRequest{
+		Sets: []*PluginSetOp{{Key: payerKey, Value: payerBytes}, {Key: allowanceKey, Value: allowBytes}},
+	})
+	if err == nil {
+		err = writeResp.Error
+	}
+	if err != nil {
+		return &PluginDeliverResponse{Error: err}
+	}
+	// run the underlying transfer as a normal send with a zero fee, since the fee payer's debit above already covers Tx.Fee
+	return c.DeliverMessageSend(ctx, &MessageSend{
+		FromAddress: msg.FromAddress, ToAddress: msg.ToAddress, Amount: msg.Amount, Sequence: msg.Sequence,
+	}, 0)
+}
+
+// MessageGrantFeeAllowance: lets a FeePayer (re)set the cumulative cap it will cover for a grantee
+type MessageGrantFeeAllowance struct {
+	Payer   []byte // the account granting the allowance; must sign
+	Grantee []byte // the account permitted to spend from this allowance via MessageSendOnBehalf
+	Limit   uint64 // the new cumulative fee cap for the grantee, effective for the current epoch
+}
+
+// CheckMessageGrantFeeAllowance() statelessly validates a 'grant_fee_allowance' message
+func (c *Contract) CheckMessageGrantFeeAllowance(_ context.Context, msg *MessageGrantFeeAllowance) *PluginCheckResponse {
+	if len(msg.Payer) != 20 {
+		return &PluginCheckResponse{Error: ErrInvalidAddress()}
+	}
+	if len(msg.Grantee) != 20 {
+		return &PluginCheckResponse{Error: ErrInvalidAddress()}
+	}
+	return &PluginCheckResponse{AuthorizedSigners: [][]byte{msg.Payer}}
+}
+
+// DeliverMessageGrantFeeAllowance() handles a 'grant_fee_allowance' message: it (re)writes the payer's allowance
+// for the grantee with a fresh Spent count, starting the new Limit off at zero spend for the current epoch
+func (c *Contract) DeliverMessageGrantFeeAllowance(ctx context.Context, msg *MessageGrantFeeAllowance) *PluginDeliverResponse {
+	resp, err := c.plugin.StateRead(ctx, c, &PluginStateReadRequest{
+		Keys: []*PluginKeyRead{{QueryId: rand.Uint64(), Key: KeyForAllowanceParams()}},
+	})
+	if err == nil {
+		err = resp.Error
+	}
+	if err != nil {
+		return &PluginDeliverResponse{Error: err}
+	}
+	params := new(AllowanceParams)
+	if err = Unmarshal(resp.Results[0].Entries[0].Value, params); err != nil {
+		return &PluginDeliverResponse{Error: err}
+	}
+	allowance := &FeeAllowance{Limit: msg.Limit, Epoch: c.currentEpoch(params)}
+	bz, err := Marshal(allowance)
+	if err != nil {
+		return &PluginDeliverResponse{Error: err}
+	}
+	writeResp, err := c.plugin.StateWrite(ctx, c, &PluginStateWriteRequest{
+		Sets: []*PluginSetOp{{Key: KeyForFeeAllowance(msg.Payer, msg.Grantee), Value: bz}},
+	})
+	if err == nil {
+		err = writeResp.Error
+	}
+	return &PluginDeliverResponse{Error: err}
+}
@@ -0,0 +1,27 @@
+package contract
+
+import (
+	"context"
+	"math/rand"
+)
+
+/* This file adds account sequence enforcement (anti-replay) and a query for clients to fetch the next sequence */
+
+// QueryAccount() handles a PluginQueryAccount request so clients can fetch an account's balance and next sequence
+func (c *Contract) QueryAccount(request *PluginQueryAccountRequest) *PluginQueryAccountResponse {
+	resp, err := c.plugin.StateRead(context.Background(), c, &PluginStateReadRequest{
+		Keys: []*PluginKeyRead{
+			{QueryId: rand.Uint64(), Key: KeyForAccount(request.Address)},
+		}})
+	if err == nil {
+		err = resp.Error
+	}
+	if err != nil {
+		return &PluginQueryAccountResponse{Error: err}
+	}
+	account := new(Account)
+	if err = Unmarshal(resp.Results[0].Entries[0].Value, account); err != nil {
+		return &PluginQueryAccountResponse{Error: err}
+	}
+	return &PluginQueryAccountResponse{Amount: account.Amount, NextSequence: account.Sequence + 1}
+}